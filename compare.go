@@ -0,0 +1,213 @@
+/*
+Additional Metrics
+Copyright (C) 2023 Ivan Latunov
+
+This program is free software; you can redistribute it and/or modify it under
+the terms of the GNU General Public License as published by the Free Software
+Foundation; either version 2 of the License, or (at your option) any later
+version.
+
+This program is distributed in the hope that it will be useful, but WITHOUT ANY
+WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A
+PARTICULAR PURPOSE.  See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with
+this program; if not, write to the Free Software Foundation, Inc., 59 Temple
+Place, Suite 330, Boston, MA 02111-1307 USA
+*/
+
+package perceptualdiff
+
+import (
+	"image"
+	"io"
+	"math"
+)
+
+// Metric identifies one or more comparison algorithms to run via Compare.
+// Values combine as a bitfield so multiple metrics can run in a single
+// pass over the pixel data.
+type Metric uint
+
+const (
+	// MetricYee runs the Yee perceptual comparison (see YeeCompare) and
+	// populates CompareResult's Reason, NumPixelsFailed, ErrorSum and
+	// ImageDifference fields.
+	MetricYee Metric = 1 << iota
+
+	// MetricSSIM computes the mean structural similarity index (Wang et
+	// al., 2004) between the two images and stores it in
+	// CompareResult.Metrics["ssim"].
+	MetricSSIM
+
+	// MetricMSE computes the mean squared error between the two images
+	// and stores it in CompareResult.Metrics["mse"].
+	MetricMSE
+
+	// MetricPSNR computes the peak signal-to-noise ratio, in decibels,
+	// derived from the mean squared error, and stores it in
+	// CompareResult.Metrics["psnr"].
+	MetricPSNR
+)
+
+// defaultSSIMWindow is used when Parameters.SSIMWindow is unset.
+const defaultSSIMWindow = 8
+
+// Compare runs the metrics selected by args.Metrics and reports whether the
+// images are considered the same by all of them. If args.Metrics is zero,
+// it defaults to MetricYee, matching a direct call to YeeCompare.
+func Compare(image_a, image_b image.Image, args Parameters, output_verbose io.Writer) (
+	identical bool,
+	result CompareResult,
+) {
+	metrics := args.Metrics
+	if metrics == 0 {
+		metrics = MetricYee
+	}
+
+	identical = true
+
+	if metrics&MetricYee != 0 {
+		var ok bool
+		ok, result = YeeCompare(image_a, image_b, args, output_verbose)
+		identical = identical && ok
+	}
+
+	if metrics&(MetricSSIM|MetricMSE|MetricPSNR) == 0 {
+		return identical, result
+	}
+
+	a_size := image_a.Bounds().Size()
+	b_size := image_b.Bounds().Size()
+	if a_size != b_size {
+		if result.Reason == "" {
+			result.Reason = ReasonDimensionMismatch
+		}
+		return false, result
+	}
+
+	a_lum := toLuminance(image_a)
+	b_lum := toLuminance(image_b)
+
+	result.Metrics = make(map[string]float64, 3)
+
+	var mse_val float64
+	if metrics&(MetricMSE|MetricPSNR) != 0 {
+		mse_val = mse(a_lum, b_lum)
+	}
+	if metrics&MetricMSE != 0 {
+		result.Metrics["mse"] = mse_val
+	}
+	if metrics&MetricPSNR != 0 {
+		result.Metrics["psnr"] = psnr(mse_val)
+	}
+	if metrics&MetricSSIM != 0 {
+		window := args.SSIMWindow
+		if window <= 0 {
+			window = defaultSSIMWindow
+		}
+		score := ssim(a_lum, b_lum, a_size.X, a_size.Y, window)
+		result.Metrics["ssim"] = score
+		if score < args.SSIMThreshold {
+			identical = false
+		}
+	}
+
+	return identical, result
+}
+
+// toLuminance converts img to a row-major slice of Rec. 709 luminance
+// values in [0, 1].
+func toLuminance(img image.Image) []float64 {
+	bounds := img.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	lum := make([]float64, w*h)
+	const maxValue = float64(0xffff)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			r, g, b, _ := img.At(bounds.Min.X+x, bounds.Min.Y+y).RGBA()
+			lum[y*w+x] = 0.2126*float64(r)/maxValue +
+				0.7152*float64(g)/maxValue +
+				0.0722*float64(b)/maxValue
+		}
+	}
+	return lum
+}
+
+// mse returns the mean squared error between a and b, which must be the
+// same length.
+func mse(a, b []float64) float64 {
+	var sum float64
+	for i := range a {
+		d := a[i] - b[i]
+		sum += d * d
+	}
+	return sum / float64(len(a))
+}
+
+// psnr returns the peak signal-to-noise ratio, in decibels, for a mean
+// squared error computed over values in [0, 1].
+func psnr(mse_val float64) float64 {
+	if mse_val == 0 {
+		return math.Inf(1)
+	}
+	return -10.0 * math.Log10(mse_val)
+}
+
+// ssim returns the mean structural similarity index (Wang et al., 2004)
+// between luminance buffers a and b of size w x h, averaged over
+// non-overlapping window x window blocks.
+func ssim(a, b []float64, w, h, window int) float64 {
+	const l = 1.0 // dynamic range of the luminance values passed in.
+	c1 := (0.01 * l) * (0.01 * l)
+	c2 := (0.03 * l) * (0.03 * l)
+
+	var sum float64
+	var count int
+	for y := 0; y+window <= h; y += window {
+		for x := 0; x+window <= w; x += window {
+			sum += ssimWindow(a, b, w, x, y, window, c1, c2)
+			count++
+		}
+	}
+	if count == 0 {
+		return 1.0
+	}
+	return sum / float64(count)
+}
+
+// ssimWindow computes the SSIM index for a single window x window block
+// starting at (x0, y0) in row-major buffers a and b of stride w.
+func ssimWindow(a, b []float64, w, x0, y0, window int, c1, c2 float64) float64 {
+	n := float64(window * window)
+
+	var sum_a, sum_b float64
+	for j := 0; j < window; j++ {
+		row := (y0+j)*w + x0
+		for i := 0; i < window; i++ {
+			sum_a += a[row+i]
+			sum_b += b[row+i]
+		}
+	}
+	mu_a := sum_a / n
+	mu_b := sum_b / n
+
+	var var_a, var_b, cov_ab float64
+	for j := 0; j < window; j++ {
+		row := (y0+j)*w + x0
+		for i := 0; i < window; i++ {
+			da := a[row+i] - mu_a
+			db := b[row+i] - mu_b
+			var_a += da * da
+			var_b += db * db
+			cov_ab += da * db
+		}
+	}
+	var_a /= n - 1
+	var_b /= n - 1
+	cov_ab /= n - 1
+
+	numerator := (2*mu_a*mu_b + c1) * (2*cov_ab + c2)
+	denominator := (mu_a*mu_a + mu_b*mu_b + c1) * (var_a + var_b + c2)
+	return numerator / denominator
+}
@@ -0,0 +1,52 @@
+package perceptualdiff_test
+
+import (
+	"image"
+	"image/color"
+	"testing"
+
+	"github.com/xswordsx/perceptualdiff"
+)
+
+func solidImage(w, h int, c color.Color) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.Set(x, y, c)
+		}
+	}
+	return img
+}
+
+func TestCompareSSIMAndMSEIdenticalImages(t *testing.T) {
+	img := solidImage(16, 16, color.Gray{Y: 128})
+
+	params := perceptualdiff.DefaultParameters
+	params.Metrics = perceptualdiff.MetricSSIM | perceptualdiff.MetricMSE | perceptualdiff.MetricPSNR
+	params.SSIMThreshold = 0.99
+
+	identical, result := perceptualdiff.Compare(img, img, params, nil)
+	if !identical {
+		t.Errorf("expected identical images to pass, got result %+v", result)
+	}
+	if result.Metrics["mse"] != 0 {
+		t.Errorf("expected mse=0 for identical images, got %v", result.Metrics["mse"])
+	}
+	if result.Metrics["ssim"] != 1 {
+		t.Errorf("expected ssim=1 for identical images, got %v", result.Metrics["ssim"])
+	}
+}
+
+func TestCompareSSIMBelowThresholdFailsDifferentImages(t *testing.T) {
+	a := solidImage(16, 16, color.Gray{Y: 0})
+	b := solidImage(16, 16, color.Gray{Y: 255})
+
+	params := perceptualdiff.DefaultParameters
+	params.Metrics = perceptualdiff.MetricSSIM
+	params.SSIMThreshold = 0.5
+
+	identical, result := perceptualdiff.Compare(a, b, params, nil)
+	if identical {
+		t.Errorf("expected very different images to fail the SSIM threshold, got result %+v", result)
+	}
+}
@@ -0,0 +1,124 @@
+/*
+Diff Visualization Modes
+Copyright (C) 2023 Ivan Latunov
+
+This program is free software; you can redistribute it and/or modify it under
+the terms of the GNU General Public License as published by the Free Software
+Foundation; either version 2 of the License, or (at your option) any later
+version.
+
+This program is distributed in the hope that it will be useful, but WITHOUT ANY
+WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A
+PARTICULAR PURPOSE.  See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with
+this program; if not, write to the Free Software Foundation, Inc., 59 Temple
+Place, Suite 330, Boston, MA 02111-1307 USA
+*/
+
+package perceptualdiff
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+)
+
+// DiffMode selects how YeeCompare renders CompareResult.ImageDifference.
+type DiffMode int
+
+const (
+	// DiffMaskBlue paints passing pixels black and failing pixels blue.
+	// This is the default and matches the original perceptualdiff tool.
+	DiffMaskBlue DiffMode = iota
+
+	// DiffHeatmap maps each pixel's error magnitude, relative to the
+	// pass/fail threshold, through an approximate viridis colormap.
+	// CompareResult.RawDelta holds the raw values behind it.
+	DiffHeatmap
+
+	// DiffOverlay dims image A and tints failing pixels red over it, at
+	// Parameters.OverlayAlpha strength.
+	DiffOverlay
+
+	// DiffSideBySide concatenates image A, the DiffMaskBlue mask, and
+	// image B horizontally, separated by 1px white columns.
+	DiffSideBySide
+)
+
+// viridisStops samples the viridis colormap at t = 0, 0.25, 0.5, 0.75, 1.
+var viridisStops = [5][3]float64{
+	{68, 1, 84},
+	{59, 82, 139},
+	{33, 145, 140},
+	{94, 201, 98},
+	{253, 231, 37},
+}
+
+// heatmapColor maps a normalized error intensity in [0, 1] through a
+// piecewise-linear approximation of the viridis colormap. Values are
+// clamped, so intensities above 1 (pixels well past the fail threshold)
+// saturate at the brightest stop.
+func heatmapColor(intensity float64) color.RGBA {
+	t := intensity
+	if t < 0 {
+		t = 0
+	}
+	if t > 1 {
+		t = 1
+	}
+
+	scaled := t * float64(len(viridisStops)-1)
+	i := int(scaled)
+	if i >= len(viridisStops)-1 {
+		i = len(viridisStops) - 2
+	}
+	frac := scaled - float64(i)
+
+	lo, hi := viridisStops[i], viridisStops[i+1]
+	return color.RGBA{
+		R: uint8(lo[0] + (hi[0]-lo[0])*frac),
+		G: uint8(lo[1] + (hi[1]-lo[1])*frac),
+		B: uint8(lo[2] + (hi[2]-lo[2])*frac),
+		A: 255,
+	}
+}
+
+// darken blends c toward black by factor (0 = unchanged, 1 = black).
+func darken(c color.RGBA, factor float64) color.RGBA {
+	scale := 1 - factor
+	return color.RGBA{
+		R: uint8(float64(c.R) * scale),
+		G: uint8(float64(c.G) * scale),
+		B: uint8(float64(c.B) * scale),
+		A: 255,
+	}
+}
+
+// tintRed blends c toward pure red by alpha (0 = unchanged, 1 = solid red).
+func tintRed(c color.RGBA, alpha float64) color.RGBA {
+	return color.RGBA{
+		R: uint8(float64(c.R)*(1-alpha) + 255*alpha),
+		G: uint8(float64(c.G) * (1 - alpha)),
+		B: uint8(float64(c.B) * (1 - alpha)),
+		A: 255,
+	}
+}
+
+// sideBySide concatenates a, diff and b horizontally, separated by 1px
+// white columns.
+func sideBySide(a image.Image, diff *image.RGBA, b image.Image) *image.RGBA {
+	bounds := diff.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+
+	out := image.NewRGBA(image.Rect(0, 0, w*3+2, h))
+	white := image.NewUniform(color.White)
+
+	draw.Draw(out, image.Rect(0, 0, w, h), a, a.Bounds().Min, draw.Src)
+	draw.Draw(out, image.Rect(w, 0, w+1, h), white, image.Point{}, draw.Src)
+	draw.Draw(out, image.Rect(w+1, 0, 2*w+1, h), diff, bounds.Min, draw.Src)
+	draw.Draw(out, image.Rect(2*w+1, 0, 2*w+2, h), white, image.Point{}, draw.Src)
+	draw.Draw(out, image.Rect(2*w+2, 0, 3*w+2, h), b, b.Bounds().Min, draw.Src)
+
+	return out
+}
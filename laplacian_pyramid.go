@@ -20,9 +20,18 @@ Place, Suite 330, Boston, MA 02111-1307 USA
 
 package perceptualdiff
 
+import "sync"
+
 // The maximum amount of pyramid levels to construct.
 const MAX_PYR_LEVELS = 8
 
+// kernel is the separable 1-D Gaussian-like kernel used to build each
+// pyramid level. The 2-D 5x5 kernel used by the original implementation is
+// the outer product kernel[i] * kernel[j], so applying it as a horizontal
+// pass followed by a vertical pass yields identical results for a fraction
+// of the multiplies.
+var kernel = []float64{0.05, 0.25, 0.4, 0.25, 0.05}
+
 // pyramid is a Laplacian pyramid.
 type pyramid struct {
 	width  int
@@ -37,12 +46,16 @@ func newPyramid(image []float64, width, height int) *pyramid {
 		height: int(height),
 	}
 
+	// Reused across every level instead of allocating fresh scratch space
+	// per convolve call.
+	scratch := make([]float64, l.width*l.height)
+
 	for i := 0; i < MAX_PYR_LEVELS; i++ {
 		if i == 0 || width*height <= 1 {
 			l.levels[i] = image
 		} else {
 			l.levels[i] = make([]float64, l.width*l.height)
-			l.convolve(l.levels[i], l.levels[i-1])
+			l.convolve(l.levels[i], l.levels[i-1], scratch)
 		}
 	}
 
@@ -55,36 +68,66 @@ func (l *pyramid) get_value(x, y, level int) float64 {
 	return l.levels[level][index]
 }
 
-func (l *pyramid) convolve(a, b []float64) {
+// convolve blurs b with the separable kernel into a, using scratch as
+// intermediate storage for the horizontal pass. Edges are handled via
+// mirror reflection, matching the combined 5x5 convolution mathematically.
+//
+// Note for anyone relying on exact historical output: the two-pass
+// summation reorders the floating-point adds relative to a single
+// 25-term 2-D sum, so results agree with it only to float64 rounding
+// error (see TestConvolveMatchesDirect2D's 1e-9 tolerance), not
+// bit-for-bit. This package's own regression tests (TestYeeCompare)
+// only assert a boolean pass/fail per image pair, not exact pixel
+// values, so none needed re-baselining; a caller snapshotting raw
+// ErrorSum or RawDelta values across this change would need to.
+func (l *pyramid) convolve(a, b, scratch []float64) {
 	if len(a) == 0 || len(b) == 0 {
 		panic("empty source or destination")
 	}
+
+	// Horizontal pass: blur each row of b into scratch.
+	wg := sync.WaitGroup{}
 	for y := 0; y < l.height; y++ {
-		for x := 0; x < l.width; x++ {
-			index := y*l.width + x
-			var result float64
-			for i := -2; i <= 2; i++ {
-				for j := -2; j <= 2; j++ {
-					nx := x + i
-					ny := y + j
-					nx = abs(nx)
-					ny = abs(ny)
+		wg.Add(1)
+		go func(y int) {
+			defer wg.Done()
+			row := y * l.width
+			for x := 0; x < l.width; x++ {
+				var result float64
+				for i := -2; i <= 2; i++ {
+					nx := abs(x + i)
 					if nx >= l.width {
 						nx = 2*l.width - nx - 1
 					}
+					result += kernel[i+2] * b[row+nx]
+				}
+				scratch[row+x] = result
+			}
+		}(y)
+	}
+	wg.Wait()
+
+	// Vertical pass: blur each column of scratch into a.
+	wg = sync.WaitGroup{}
+	for y := 0; y < l.height; y++ {
+		wg.Add(1)
+		go func(y int) {
+			defer wg.Done()
+			row := y * l.width
+			for x := 0; x < l.width; x++ {
+				var result float64
+				for j := -2; j <= 2; j++ {
+					ny := abs(y + j)
 					if ny >= l.height {
 						ny = 2*l.height - ny - 1
 					}
-
-					kernel := []float64{0.05, 0.25, 0.4, 0.25, 0.05}
-
-					result +=
-						kernel[i+2] * kernel[j+2] * b[ny*l.width+nx]
+					result += kernel[j+2] * scratch[ny*l.width+x]
 				}
+				a[row+x] = result
 			}
-			a[index] = result
-		}
+		}(y)
 	}
+	wg.Wait()
 }
 
 func abs(x int) int {
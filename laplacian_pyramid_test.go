@@ -0,0 +1,63 @@
+package perceptualdiff
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+// direct2DConvolve is a reference implementation of the original (pre
+// separable-kernel) 5x5 convolution, used to confirm convolve still agrees
+// with it closely enough for regression tests even though the two-pass
+// summation order is not bit-for-bit identical.
+func direct2DConvolve(b []float64, width, height int) []float64 {
+	a := make([]float64, width*height)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			var result float64
+			for j := -2; j <= 2; j++ {
+				ny := abs(y + j)
+				if ny >= height {
+					ny = 2*height - ny - 1
+				}
+				for i := -2; i <= 2; i++ {
+					nx := abs(x + i)
+					if nx >= width {
+						nx = 2*width - nx - 1
+					}
+					result += kernel[i+2] * kernel[j+2] * b[ny*width+nx]
+				}
+			}
+			a[y*width+x] = result
+		}
+	}
+	return a
+}
+
+// TestConvolveMatchesDirect2D confirms the separable two-pass convolve
+// agrees with the original single-pass 5x5 convolution to within float64
+// rounding error. The two are not bit-identical, since summing 25 terms in
+// one pass reorders the adds relative to two 5-term passes.
+func TestConvolveMatchesDirect2D(t *testing.T) {
+	const width, height = 17, 13
+	r := rand.New(rand.NewSource(1))
+	b := make([]float64, width*height)
+	for i := range b {
+		b[i] = r.Float64() * 255
+	}
+
+	want := direct2DConvolve(b, width, height)
+
+	l := &pyramid{width: width, height: height}
+	got := make([]float64, width*height)
+	scratch := make([]float64, width*height)
+	l.convolve(got, b, scratch)
+
+	const tolerance = 1e-9
+	for i := range want {
+		if math.Abs(got[i]-want[i]) > tolerance {
+			t.Fatalf("convolve()[%d] = %v, direct2DConvolve()[%d] = %v (diff %v exceeds tolerance %v)",
+				i, got[i], i, want[i], math.Abs(got[i]-want[i]), tolerance)
+		}
+	}
+}
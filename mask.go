@@ -0,0 +1,48 @@
+/*
+Mask
+Copyright (C) 2023 Ivan Latunov
+
+This program is free software; you can redistribute it and/or modify it under
+the terms of the GNU General Public License as published by the Free Software
+Foundation; either version 2 of the License, or (at your option) any later
+version.
+
+This program is distributed in the hope that it will be useful, but WITHOUT ANY
+WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A
+PARTICULAR PURPOSE.  See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with
+this program; if not, write to the Free Software Foundation, Inc., 59 Temple
+Place, Suite 330, Boston, MA 02111-1307 USA
+*/
+
+package perceptualdiff
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+)
+
+// LoadMaskPNG decodes a PNG image from r and returns it as an *image.Alpha
+// mask suitable for Parameters.IgnoreMask. A pixel with a nonzero alpha
+// channel in the source PNG marks the corresponding pixel as ignored;
+// fully transparent pixels are not.
+func LoadMaskPNG(r io.Reader) (*image.Alpha, error) {
+	img, err := png.Decode(r)
+	if err != nil {
+		return nil, fmt.Errorf("could not decode mask PNG: %w", err)
+	}
+
+	bounds := img.Bounds()
+	mask := image.NewAlpha(bounds)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			_, _, _, a := img.At(x, y).RGBA()
+			mask.SetAlpha(x, y, color.Alpha{A: uint8(a >> 8)})
+		}
+	}
+	return mask, nil
+}
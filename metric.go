@@ -23,8 +23,10 @@ Place, Suite 330, Boston, MA 02111-1307 USA
 package perceptualdiff
 
 import (
+	"fmt"
 	"image"
 	"image/color"
+	"image/png"
 	"io"
 	"math"
 	"sync"
@@ -36,6 +38,26 @@ const (
 	ReasonBinaryIdentical   = "Images are binary identical"
 	ReasonIndistinguishable = "Images are perceptually indistinguishable"
 	ReasonVisiblyDifferent  = "Images are visibly different"
+	ReasonAlphaMismatch     = "Image alpha channels do not match"
+)
+
+// ColorSpace identifies the assumed color space of the input pixels, used
+// to convert gamma-encoded RGB to CIE XYZ before the perceptual comparison.
+type ColorSpace int
+
+const (
+	// ColorSpaceSRGB treats input pixels as sRGB (IEC 61966-2-1), the
+	// color space produced by Go's image/png and image/jpeg decoders.
+	// This is the default.
+	ColorSpaceSRGB ColorSpace = iota
+
+	// ColorSpaceAdobeRGB treats input pixels as Adobe RGB (1998), matching
+	// the assumption made by the original perceptualdiff tool.
+	ColorSpaceAdobeRGB
+
+	// ColorSpaceLinear treats input pixels as already linear light, so no
+	// gamma/companding is applied before the RGB-to-XYZ matrix.
+	ColorSpaceLinear
 )
 
 // Parameters are the available parameters for image comparison.
@@ -46,7 +68,8 @@ type Parameters struct {
 	// Field of view in degrees. Range is [0.1, 89.9].
 	FieldOfView float64
 
-	// The Gamma to convert to linear color space.
+	// The Gamma to convert to linear color space. Only used when
+	// ColorSpace is ColorSpaceAdobeRGB.
 	Gamma float64
 
 	// White luminance.
@@ -59,6 +82,95 @@ type Parameters struct {
 	//   - 0.0 is the same as ``LuminanceOnly'' = true,
 	//   - 1.0 means full strength.
 	ColorFactor float64
+
+	// ColorSpace selects the assumed color space of the input pixels.
+	// Ignored if RGBToXYZ is set. Defaults to ColorSpaceSRGB.
+	ColorSpace ColorSpace
+
+	// RGBToXYZ overrides ColorSpace with a custom conversion from
+	// gamma-encoded [0, 1] RGB to CIE XYZ, for color spaces other than the
+	// ones ColorSpace supports.
+	RGBToXYZ func(r, g, b float64) (x, y, z float64)
+
+	// IgnoreRects excludes the given pixel rectangles from the comparison.
+	// Pixels inside them never count towards NumPixelsFailed or ErrorSum
+	// and are painted gray in ImageDifference.
+	IgnoreRects []image.Rectangle
+
+	// IgnoreMask excludes any pixel with a nonzero alpha value from the
+	// comparison, the same way IgnoreRects does. Use LoadMaskPNG to build
+	// one from a PNG mask image.
+	IgnoreMask *image.Alpha
+
+	// Metrics selects which metrics [Compare] runs, as a bitfield of
+	// MetricYee, MetricSSIM, MetricMSE and MetricPSNR. Zero defaults to
+	// MetricYee.
+	Metrics Metric
+
+	// SSIMThreshold is the minimum mean SSIM score, in [0, 1], for the
+	// images to be considered the same when MetricSSIM is selected.
+	SSIMThreshold float64
+
+	// SSIMWindow is the side length, in pixels, of the square window SSIM
+	// is averaged over. Defaults to 8 if zero or negative.
+	SSIMWindow int
+
+	// Preprocess controls normalization applied by CompareReaders before
+	// the comparison runs. Ignored by YeeCompare and Compare, which only
+	// ever see already-decoded images.
+	Preprocess Preprocess
+
+	// DiffMode selects how CompareResult.ImageDifference is rendered.
+	// Defaults to DiffMaskBlue.
+	DiffMode DiffMode
+
+	// OverlayAlpha is the blend strength, in [0, 1], used to tint failing
+	// pixels red when DiffMode is DiffOverlay. Defaults to 0.6 if zero or
+	// negative.
+	OverlayAlpha float64
+
+	// ReferenceWidth overrides the image width used to compute
+	// pixels-per-degree for the CSF model, in case a is a sub-tile of a
+	// larger image whose frequency response it needs to match (see
+	// YeeCompareTiled). Zero means use the compared image's own width.
+	ReferenceWidth int
+}
+
+// isIgnored reports whether pixel (x, y) should be excluded from the
+// perceptual test, either because it falls within an IgnoreRects entry or
+// because IgnoreMask marks it with a nonzero alpha value.
+func (p Parameters) isIgnored(x, y int) bool {
+	pt := image.Pt(x, y)
+	for _, r := range p.IgnoreRects {
+		if pt.In(r) {
+			return true
+		}
+	}
+	if p.IgnoreMask != nil && p.IgnoreMask.AlphaAt(x, y).A != 0 {
+		return true
+	}
+	return false
+}
+
+// rgbToXYZFunc resolves the RGB-to-XYZ conversion to use for a comparison,
+// honoring RGBToXYZ if set and otherwise dispatching on ColorSpace.
+func (p Parameters) rgbToXYZFunc() func(r, g, b float64) (x, y, z float64) {
+	if p.RGBToXYZ != nil {
+		return p.RGBToXYZ
+	}
+	switch p.ColorSpace {
+	case ColorSpaceAdobeRGB:
+		gamma := p.Gamma
+		return func(r, g, b float64) (float64, float64, float64) {
+			return adobe_rgb_to_xyz(math.Pow(r, gamma), math.Pow(g, gamma), math.Pow(b, gamma))
+		}
+	case ColorSpaceLinear:
+		return adobe_rgb_to_xyz
+	default: // ColorSpaceSRGB
+		return func(r, g, b float64) (float64, float64, float64) {
+			return srgb_to_xyz(srgb_companding(r), srgb_companding(g), srgb_companding(b))
+		}
+	}
 }
 
 // CompareResult is the result of a comparison between two images.
@@ -67,29 +179,50 @@ type CompareResult struct {
 	NumPixelsFailed uint64      // Number of pixels that failed the perceptual check.
 	ErrorSum        float64     // Sum of the deltas of all pixels.
 	ImageDifference *image.RGBA // Bitmask that shows which pixels failed the check.
-}
-
-var (
-	// DefaultParameters are the default parameters for the [Yee_compare] func.
-	DefaultParameters Parameters
 
-	global_white struct{ x, y, z float64 }
-)
+	// Metrics holds the score produced by each additional metric selected
+	// via Parameters.Metrics, keyed by name ("ssim", "mse", "psnr"). Nil
+	// unless one of those metrics was requested.
+	Metrics map[string]float64
+
+	// DiffMode is the mode ImageDifference was rendered with.
+	DiffMode DiffMode
+
+	// RawDelta holds the per-pixel normalized error magnitude, in
+	// row-major order, when DiffMode is DiffHeatmap. Nil otherwise.
+	RawDelta []float64
+
+	// pixelError holds each pixel's total error contribution (the
+	// luminance delta plus, unless LuminanceOnly, the color delta E term)
+	// in row-major order, summing to ErrorSum. Unexported: it exists so
+	// YeeCompareTiled can sum exactly the interior-pixel share of a
+	// tile's error instead of approximating it, and its contents depend
+	// on internal test details that aren't meant to be a public API.
+	pixelError []float64
+}
 
-func init() {
-	x, y, z := adobe_rgb_to_xyz(1, 1, 1)
-	global_white.x = x
-	global_white.y = y
-	global_white.z = z
-
-	DefaultParameters = Parameters{
-		LuminanceOnly:   false,
-		FieldOfView:     45.0,
-		Gamma:           2.2,
-		Luminance:       100.0,
-		ThresholdPixels: 100,
-		ColorFactor:     1.0,
+// SaveComparison writes r.ImageDifference as a PNG. mode must match the
+// DiffMode the result was produced with, so callers can't silently save a
+// visualization that no longer matches Parameters.DiffMode.
+func (r CompareResult) SaveComparison(w io.Writer, mode DiffMode) error {
+	if r.ImageDifference == nil {
+		return fmt.Errorf("perceptualdiff: no image difference to save")
+	}
+	if mode != r.DiffMode {
+		return fmt.Errorf("perceptualdiff: mode %d does not match the result's DiffMode %d", mode, r.DiffMode)
 	}
+	return png.Encode(w, r.ImageDifference)
+}
+
+// DefaultParameters are the default parameters for the [YeeCompare] func.
+var DefaultParameters = Parameters{
+	LuminanceOnly:   false,
+	FieldOfView:     45.0,
+	Gamma:           2.2,
+	Luminance:       100.0,
+	ThresholdPixels: 100,
+	ColorFactor:     1.0,
+	ColorSpace:      ColorSpaceSRGB,
 }
 
 // Image comparison metric using Yee's method.
@@ -144,8 +277,9 @@ func YeeCompare(image_a, image_b image.Image, args Parameters, output_verbose io
 
 	_, _ = output_verbose.Write([]byte("Converting RGB to XYZ\n"))
 
-	gamma := args.Gamma
 	luminance := args.Luminance
+	rgb_to_xyz := args.rgbToXYZFunc()
+	white_x, white_y, white_z := rgb_to_xyz(1, 1, 1)
 
 	wg := sync.WaitGroup{}
 	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
@@ -160,21 +294,21 @@ func YeeCompare(image_a, image_b image.Image, args Parameters, output_verbose io
 				a_color_R, a_color_G, a_color_B, _ := image_a.At(x, y).RGBA()
 				const maxValue = float64(0xffff)
 
-				a_x, a_y, a_z := adobe_rgb_to_xyz(
-					math.Pow(float64(a_color_R)/maxValue, gamma),
-					math.Pow(float64(a_color_G)/maxValue, gamma),
-					math.Pow(float64(a_color_B)/maxValue, gamma),
+				a_x, a_y, a_z := rgb_to_xyz(
+					float64(a_color_R)/maxValue,
+					float64(a_color_G)/maxValue,
+					float64(a_color_B)/maxValue,
 				)
-				_, a_a[i], a_b[i] = xyz_to_lab(a_x, a_y, a_z)
+				_, a_a[i], a_b[i] = xyz_to_lab(a_x, a_y, a_z, white_x, white_y, white_z)
 
 				b_color_R, b_color_G, b_color_B, _ := image_b.At(x, y).RGBA()
 
-				b_x, b_y, b_z := adobe_rgb_to_xyz(
-					math.Pow(float64(b_color_R)/maxValue, gamma),
-					math.Pow(float64(b_color_G)/maxValue, gamma),
-					math.Pow(float64(b_color_B)/maxValue, gamma),
+				b_x, b_y, b_z := rgb_to_xyz(
+					float64(b_color_R)/maxValue,
+					float64(b_color_G)/maxValue,
+					float64(b_color_B)/maxValue,
 				)
-				_, b_a[i], b_b[i] = xyz_to_lab(b_x, b_y, b_z)
+				_, b_a[i], b_b[i] = xyz_to_lab(b_x, b_y, b_z, white_x, white_y, white_z)
 
 				a_lum[i] = a_y * luminance
 				b_lum[i] = b_y * luminance
@@ -184,8 +318,13 @@ func YeeCompare(image_a, image_b image.Image, args Parameters, output_verbose io
 
 	wg.Wait()
 
+	cpd_width := w
+	if args.ReferenceWidth > 0 {
+		cpd_width = args.ReferenceWidth
+	}
+
 	num_one_degree_pixels := to_degrees(2 * math.Tan(args.FieldOfView*to_radians(.5)))
-	pixels_per_degree := float64(w) / num_one_degree_pixels
+	pixels_per_degree := float64(cpd_width) / num_one_degree_pixels
 
 	_, _ = output_verbose.Write([]byte("Performing test\n"))
 
@@ -212,6 +351,23 @@ func YeeCompare(image_a, image_b image.Image, args Parameters, output_verbose io
 
 	diffImg := image.NewRGBA(image_a.Bounds())
 
+	var raw_delta []float64
+	if args.DiffMode == DiffHeatmap {
+		raw_delta = make([]float64, dim)
+	}
+	// Only YeeCompareTiled needs per-pixel error, to sum exactly a tile's
+	// interior share of a sub-image comparison; it signals that via
+	// ReferenceWidth, so ordinary callers don't pay for this allocation.
+	var pixel_errors []float64
+	if args.ReferenceWidth > 0 {
+		pixel_errors = make([]float64, dim)
+	}
+
+	overlay_alpha := args.OverlayAlpha
+	if overlay_alpha <= 0 {
+		overlay_alpha = 0.6
+	}
+
 	la := newPyramid(a_lum, w, h)
 	lb := newPyramid(b_lum, w, h)
 
@@ -223,6 +379,11 @@ func YeeCompare(image_a, image_b image.Image, args Parameters, output_verbose io
 			for x := 0; x < w; x++ {
 				index := y*w + x
 
+				if args.isIgnored(x, y) {
+					diffImg.SetRGBA(x, y, color.RGBA{128, 128, 128, 255})
+					continue
+				}
+
 				adapt := math.Max(
 					(la.get_value(x, y, adaptation_level)+lb.get_value(x, y, adaptation_level))*0.5,
 					1e-5)
@@ -251,6 +412,7 @@ func YeeCompare(image_a, image_b image.Image, args Parameters, output_verbose io
 				delta := math.Abs(la.get_value(x, y, 0) -
 					lb.get_value(x, y, 0))
 				atomicAddFloat64(&error_sum, delta)
+				pixel_error := delta
 				pass := true
 
 				// Pure luminance test.
@@ -272,16 +434,42 @@ func YeeCompare(image_a, image_b image.Image, args Parameters, output_verbose io
 					db := a_b[index] - b_b[index]
 					delta_e := (da*da + db*db) * color_scale
 					atomicAddFloat64(&error_sum, delta_e)
+					pixel_error += delta_e
 					if delta_e > factor {
 						pass = false
 					}
 				}
 
-				if pass {
-					diffImg.SetRGBA(int(x), int(y), color.RGBA{0, 0, 0, 255})
-				} else {
+				if pixel_errors != nil {
+					pixel_errors[index] = pixel_error
+				}
+
+				if !pass {
 					pixels_failed.Add(1)
-					diffImg.SetRGBA(int(x), int(y), color.RGBA{0, 0, 255, 255})
+				}
+
+				switch args.DiffMode {
+				case DiffHeatmap:
+					threshold := factor * tvi(adapt)
+					intensity := delta / math.Max(2*threshold, 1e-5)
+					raw_delta[index] = intensity
+					diffImg.SetRGBA(x, y, heatmapColor(intensity))
+
+				case DiffOverlay:
+					ar, ag, ab, _ := image_a.At(x, y).RGBA()
+					base := color.RGBA{R: uint8(ar >> 8), G: uint8(ag >> 8), B: uint8(ab >> 8), A: 255}
+					if pass {
+						diffImg.SetRGBA(x, y, darken(base, 0.5))
+					} else {
+						diffImg.SetRGBA(x, y, tintRed(base, overlay_alpha))
+					}
+
+				default: // DiffMaskBlue, DiffSideBySide (the mask is also the middle panel)
+					if pass {
+						diffImg.SetRGBA(x, y, color.RGBA{0, 0, 0, 255})
+					} else {
+						diffImg.SetRGBA(x, y, color.RGBA{0, 0, 255, 255})
+					}
 				}
 			}
 		}(y)
@@ -289,6 +477,10 @@ func YeeCompare(image_a, image_b image.Image, args Parameters, output_verbose io
 
 	wg.Wait()
 
+	if args.DiffMode == DiffSideBySide {
+		diffImg = sideBySide(image_a, diffImg, image_b)
+	}
+
 	var (
 		perceptuallyIdentical bool = uint(pixels_failed.Load()) < args.ThresholdPixels
 		reason                string
@@ -304,6 +496,9 @@ func YeeCompare(image_a, image_b image.Image, args Parameters, output_verbose io
 		NumPixelsFailed: pixels_failed.Load(),
 		ErrorSum:        atomicLoadFloat64(&error_sum),
 		ImageDifference: diffImg,
+		DiffMode:        args.DiffMode,
+		RawDelta:        raw_delta,
+		pixelError:      pixel_errors,
 	}
 }
 
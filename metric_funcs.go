@@ -22,15 +22,6 @@ package perceptualdiff
 
 import "math"
 
-var white struct{ x, y, z float64 }
-
-func init() {
-	x, y, z := adobe_rgb_to_xyz(1, 1, 1)
-	white.x = x
-	white.y = y
-	white.z = z
-}
-
 func to_radians(degrees float64) float64 {
 	return degrees * math.Pi / 180.0
 }
@@ -93,13 +84,32 @@ func adobe_rgb_to_xyz(r, g, b float64) (float64, float64, float64) {
 		r*0.0270328 + g*0.0706879 + b*0.991248
 }
 
-func xyz_to_lab(x, y, z float64) (l, a, b float64) {
+// convert sRGB (IEC 61966-2-1) with reference white D65 to XYZ
+func srgb_to_xyz(r, g, b float64) (float64, float64, float64) {
+	// matrix is from http://www.brucelindbloom.com/
+	return r*0.4124564 + g*0.3575761 + b*0.1804375,
+		r*0.2126729 + g*0.7151522 + b*0.0721750,
+		r*0.0193339 + g*0.1191920 + b*0.9503041
+}
+
+// srgb_companding linearizes a single gamma-encoded sRGB channel value in
+// [0, 1], per the piecewise transfer function in IEC 61966-2-1.
+func srgb_companding(v float64) float64 {
+	if v <= 0.04045 {
+		return v / 12.92
+	}
+	return math.Pow((v+0.055)/1.055, 2.4)
+}
+
+// xyz_to_lab converts a CIE XYZ color to CIE L*a*b*, relative to the
+// reference white point (white_x, white_y, white_z).
+func xyz_to_lab(x, y, z, white_x, white_y, white_z float64) (l, a, b float64) {
 	const epsilon = 216.0 / 24389.0
 	const kappa = 24389.0 / 27.0
 	var r = [3]float64{
-		x / white.x,
-		y / white.y,
-		z / white.z,
+		x / white_x,
+		y / white_y,
+		z / white_z,
 	}
 	var f [3]float64
 	for i := 0; i < 3; i++ {
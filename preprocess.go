@@ -0,0 +1,370 @@
+/*
+Preprocessing
+Copyright (C) 2023 Ivan Latunov
+
+This program is free software; you can redistribute it and/or modify it under
+the terms of the GNU General Public License as published by the Free Software
+Foundation; either version 2 of the License, or (at your option) any later
+version.
+
+This program is distributed in the hope that it will be useful, but WITHOUT ANY
+WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A
+PARTICULAR PURPOSE.  See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with
+this program; if not, write to the Free Software Foundation, Inc., 59 Temple
+Place, Suite 330, Boston, MA 02111-1307 USA
+*/
+
+package perceptualdiff
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"io"
+
+	xdraw "golang.org/x/image/draw"
+)
+
+// ResampleMode selects how CompareReaders reconciles images of different
+// sizes before comparison.
+type ResampleMode int
+
+const (
+	// ResampleNone leaves mismatched dimensions alone; CompareReaders
+	// reports ReasonDimensionMismatch just like YeeCompare does.
+	ResampleNone ResampleMode = iota
+
+	// ResampleFitSmaller downsamples the larger image to match the
+	// smaller one's dimensions.
+	ResampleFitSmaller
+
+	// ResampleFitLarger upsamples the smaller image to match the larger
+	// one's dimensions.
+	ResampleFitLarger
+)
+
+// AlphaHandling selects how CompareReaders deals with the alpha channel.
+type AlphaHandling int
+
+const (
+	// AlphaIgnore leaves the alpha channel untouched. This is the default.
+	AlphaIgnore AlphaHandling = iota
+
+	// AlphaComposite flattens both images onto AlphaBackground before
+	// comparing, so differing-but-equivalent alpha no longer matters.
+	AlphaComposite
+
+	// AlphaFailOnMismatch reports ReasonAlphaMismatch if any pixel's
+	// alpha value differs between the two images.
+	AlphaFailOnMismatch
+)
+
+// Preprocess controls how CompareReaders normalizes two images before
+// running the perceptual comparison. The zero value performs no
+// normalization at all.
+type Preprocess struct {
+	// AutoOrient rotates/flips each image according to its EXIF
+	// Orientation tag, if the source stream carries one. Go's image
+	// package discards this metadata on decode, so it can only be
+	// honored when CompareReaders still has the original bytes.
+	AutoOrient bool
+
+	// Resample reconciles differing image dimensions. Defaults to
+	// ResampleNone.
+	Resample ResampleMode
+
+	// AlphaHandling selects how the alpha channel is treated. Defaults to
+	// AlphaIgnore.
+	AlphaHandling AlphaHandling
+
+	// AlphaBackground is the color images are flattened onto when
+	// AlphaHandling is AlphaComposite. Defaults to opaque white.
+	AlphaBackground color.Color
+}
+
+// CompareReaders decodes a and b, applies args.Preprocess, and runs
+// Compare on the result. Prefer it over Compare whenever
+// Parameters.Preprocess.AutoOrient is set, since EXIF orientation
+// metadata does not survive image.Decode.
+func CompareReaders(a, b io.Reader, args Parameters, output_verbose io.Writer) (bool, CompareResult) {
+	image_a, orientation_a, err := decodeWithOrientation(a, args.Preprocess.AutoOrient)
+	if err != nil {
+		return false, CompareResult{Reason: fmt.Sprintf("could not decode image A: %v", err)}
+	}
+	image_b, orientation_b, err := decodeWithOrientation(b, args.Preprocess.AutoOrient)
+	if err != nil {
+		return false, CompareResult{Reason: fmt.Sprintf("could not decode image B: %v", err)}
+	}
+
+	if args.Preprocess.AutoOrient {
+		image_a = applyOrientation(image_a, orientation_a)
+		image_b = applyOrientation(image_b, orientation_b)
+	}
+
+	image_a, image_b = resampleToMatch(image_a, image_b, args.Preprocess.Resample)
+
+	switch args.Preprocess.AlphaHandling {
+	case AlphaComposite:
+		background := args.Preprocess.AlphaBackground
+		if background == nil {
+			background = color.White
+		}
+		image_a = compositeOver(image_a, background)
+		image_b = compositeOver(image_b, background)
+	case AlphaFailOnMismatch:
+		if !alphaMatches(image_a, image_b) {
+			return false, CompareResult{Reason: ReasonAlphaMismatch}
+		}
+	}
+
+	return Compare(image_a, image_b, args, output_verbose)
+}
+
+// decodeWithOrientation decodes r and, if wantOrientation is set, extracts
+// its EXIF Orientation tag (1 if absent or unparsable).
+func decodeWithOrientation(r io.Reader, wantOrientation bool) (image.Image, int, error) {
+	raw, err := io.ReadAll(r)
+	if err != nil {
+		return nil, 1, fmt.Errorf("could not read image: %w", err)
+	}
+	img, _, err := image.Decode(bytes.NewReader(raw))
+	if err != nil {
+		return nil, 1, fmt.Errorf("could not decode image: %w", err)
+	}
+	if !wantOrientation {
+		return img, 1, nil
+	}
+	return img, jpegExifOrientation(raw), nil
+}
+
+// jpegExifOrientation scans a JPEG byte stream for an APP1 Exif segment and
+// returns its Orientation tag (1..8), or 1 if the stream isn't a JPEG, has
+// no Exif segment, or has no Orientation tag.
+func jpegExifOrientation(raw []byte) int {
+	if len(raw) < 4 || raw[0] != 0xFF || raw[1] != 0xD8 {
+		return 1
+	}
+
+	const exifHeader = "Exif\x00\x00"
+	pos := 2
+	for pos+4 <= len(raw) {
+		if raw[pos] != 0xFF {
+			break
+		}
+		marker := raw[pos+1]
+		if marker == 0xD8 || marker == 0xD9 {
+			pos += 2
+			continue
+		}
+		if marker == 0xDA {
+			break // start of scan: no more metadata markers follow.
+		}
+
+		size := int(raw[pos+2])<<8 | int(raw[pos+3])
+		if size < 2 || pos+2+size > len(raw) {
+			break
+		}
+		// size includes the 2 length bytes themselves, so the segment
+		// payload (the part that may start with "Exif\x00\x00") begins 4
+		// bytes after the marker, not 2.
+		segment := raw[pos+4 : pos+2+size]
+		if marker == 0xE1 && len(segment) > len(exifHeader) && string(segment[:len(exifHeader)]) == exifHeader {
+			return exifOrientation(segment[len(exifHeader):])
+		}
+		pos += 2 + size
+	}
+	return 1
+}
+
+// exifOrientation reads the Orientation tag (0x0112) out of a TIFF-encoded
+// IFD0, per the Exif 2.3 specification.
+func exifOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 1
+	}
+
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+
+	ifdOffset := order.Uint32(tiff[4:8])
+	if int(ifdOffset)+2 > len(tiff) {
+		return 1
+	}
+	entryCount := int(order.Uint16(tiff[ifdOffset : ifdOffset+2]))
+
+	for i := 0; i < entryCount; i++ {
+		entry := int(ifdOffset) + 2 + i*12
+		if entry+12 > len(tiff) {
+			break
+		}
+		if order.Uint16(tiff[entry:entry+2]) != 0x0112 {
+			continue
+		}
+		value := int(order.Uint16(tiff[entry+8 : entry+10]))
+		if value < 1 || value > 8 {
+			return 1
+		}
+		return value
+	}
+	return 1
+}
+
+// applyOrientation rotates/flips img according to an Exif Orientation tag.
+func applyOrientation(img image.Image, orientation int) image.Image {
+	switch orientation {
+	case 2:
+		return flipHorizontal(img)
+	case 3:
+		return rotate180(img)
+	case 4:
+		return flipVertical(img)
+	case 5:
+		return flipHorizontal(rotate90(img))
+	case 6:
+		return rotate90(img)
+	case 7:
+		return flipHorizontal(rotate270(img))
+	case 8:
+		return rotate270(img)
+	default:
+		return img
+	}
+}
+
+func rotate90(img image.Image) *image.RGBA {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	dst := image.NewRGBA(image.Rect(0, 0, h, w))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			dst.Set(h-1-y, x, img.At(b.Min.X+x, b.Min.Y+y))
+		}
+	}
+	return dst
+}
+
+func rotate180(img image.Image) *image.RGBA {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			dst.Set(w-1-x, h-1-y, img.At(b.Min.X+x, b.Min.Y+y))
+		}
+	}
+	return dst
+}
+
+func rotate270(img image.Image) *image.RGBA {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	dst := image.NewRGBA(image.Rect(0, 0, h, w))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			dst.Set(y, w-1-x, img.At(b.Min.X+x, b.Min.Y+y))
+		}
+	}
+	return dst
+}
+
+func flipHorizontal(img image.Image) *image.RGBA {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			dst.Set(w-1-x, y, img.At(b.Min.X+x, b.Min.Y+y))
+		}
+	}
+	return dst
+}
+
+func flipVertical(img image.Image) *image.RGBA {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			dst.Set(x, h-1-y, img.At(b.Min.X+x, b.Min.Y+y))
+		}
+	}
+	return dst
+}
+
+// resampleToMatch resizes a and/or b to a common size chosen by mode. If
+// mode is ResampleNone, or the images are already the same size, both are
+// returned unchanged.
+func resampleToMatch(a, b image.Image, mode ResampleMode) (image.Image, image.Image) {
+	a_size := a.Bounds().Size()
+	b_size := b.Bounds().Size()
+	if mode == ResampleNone || a_size == b_size {
+		return a, b
+	}
+
+	target := a_size
+	a_area := a_size.X * a_size.Y
+	b_area := b_size.X * b_size.Y
+	switch mode {
+	case ResampleFitSmaller:
+		if b_area < a_area {
+			target = b_size
+		}
+	case ResampleFitLarger:
+		if b_area > a_area {
+			target = b_size
+		}
+	}
+
+	return resampleTo(a, target), resampleTo(b, target)
+}
+
+// resampleTo scales img to size using a Catmull-Rom filter, which is a
+// good default for both up- and downsampling.
+func resampleTo(img image.Image, size image.Point) image.Image {
+	if img.Bounds().Size() == size {
+		return img
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, size.X, size.Y))
+	xdraw.CatmullRom.Scale(dst, dst.Bounds(), img, img.Bounds(), xdraw.Over, nil)
+	return dst
+}
+
+// compositeOver flattens img onto an opaque background of the given color.
+func compositeOver(img image.Image, background color.Color) image.Image {
+	b := img.Bounds()
+	dst := image.NewRGBA(b)
+	draw.Draw(dst, b, image.NewUniform(background), image.Point{}, draw.Src)
+	draw.Draw(dst, b, img, b.Min, draw.Over)
+	return dst
+}
+
+// alphaMatches reports whether a and b have identical bounds and, at every
+// pixel, identical alpha values.
+func alphaMatches(a, b image.Image) bool {
+	bounds := a.Bounds()
+	if bounds != b.Bounds() {
+		return false
+	}
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			_, _, _, alpha_a := a.At(x, y).RGBA()
+			_, _, _, alpha_b := b.At(x, y).RGBA()
+			if alpha_a != alpha_b {
+				return false
+			}
+		}
+	}
+	return true
+}
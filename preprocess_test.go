@@ -0,0 +1,104 @@
+package perceptualdiff_test
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"testing"
+
+	"github.com/xswordsx/perceptualdiff"
+)
+
+// quadrantImage returns a w x h image split into two solid-colored halves
+// along the x axis, so rotating it changes what the pixels look like.
+func quadrantImage(w, h int, left, right color.Color) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			if x < w/2 {
+				img.Set(x, y, left)
+			} else {
+				img.Set(x, y, right)
+			}
+		}
+	}
+	return img
+}
+
+// rotate180 rotates img by 180 degrees. It is a standalone reimplementation
+// (not the package's own rotate180) so the test exercises AutoOrient as a
+// black box rather than assuming its internals.
+func rotate180(img image.Image) *image.RGBA {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			dst.Set(w-1-x, h-1-y, img.At(b.Min.X+x, b.Min.Y+y))
+		}
+	}
+	return dst
+}
+
+// encodeJPEGWithOrientation JPEG-encodes img at the highest quality and
+// splices in a minimal APP1 Exif segment carrying the given Orientation
+// tag, right after the SOI marker.
+func encodeJPEGWithOrientation(t *testing.T, img image.Image, orientation uint16) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 100}); err != nil {
+		t.Fatalf("could not encode JPEG: %v", err)
+	}
+	raw := buf.Bytes()
+	if len(raw) < 2 || raw[0] != 0xFF || raw[1] != 0xD8 {
+		t.Fatalf("encoded image is not a JPEG")
+	}
+
+	// TIFF header ("II" = little-endian) + IFD0 with a single Orientation
+	// (0x0112) SHORT entry, per the Exif 2.3 specification.
+	tiff := []byte{
+		'I', 'I', 0x2A, 0x00, // byte order + TIFF magic
+		0x08, 0x00, 0x00, 0x00, // offset of IFD0
+		0x01, 0x00, // 1 entry
+		0x12, 0x01, // tag 0x0112 (Orientation)
+		0x03, 0x00, // type 3 (SHORT)
+		0x01, 0x00, 0x00, 0x00, // count 1
+		byte(orientation), byte(orientation >> 8), 0x00, 0x00, // value
+		0x00, 0x00, 0x00, 0x00, // next IFD offset
+	}
+	payload := append([]byte("Exif\x00\x00"), tiff...)
+
+	app1 := []byte{0xFF, 0xE1, byte((len(payload) + 2) >> 8), byte(len(payload) + 2)}
+	app1 = append(app1, payload...)
+
+	out := make([]byte, 0, len(raw)+len(app1))
+	out = append(out, raw[:2]...) // SOI
+	out = append(out, app1...)
+	out = append(out, raw[2:]...)
+	return out
+}
+
+func TestCompareReadersAutoOrientUndoesExifRotation(t *testing.T) {
+	upright := quadrantImage(32, 32, color.RGBA{255, 0, 0, 255}, color.RGBA{0, 0, 255, 255})
+	stored := rotate180(upright)
+
+	uprightJPEG := encodeJPEGWithOrientation(t, upright, 1)
+	storedJPEG := encodeJPEGWithOrientation(t, stored, 3) // Orientation 3 = rotate 180 to display correctly.
+
+	params := perceptualdiff.DefaultParameters
+
+	identical, result := perceptualdiff.CompareReaders(
+		bytes.NewReader(uprightJPEG), bytes.NewReader(storedJPEG), params, nil)
+	if identical {
+		t.Errorf("expected rotated image to differ from upright without AutoOrient, got result %+v", result)
+	}
+
+	params.Preprocess.AutoOrient = true
+	identical, result = perceptualdiff.CompareReaders(
+		bytes.NewReader(uprightJPEG), bytes.NewReader(storedJPEG), params, nil)
+	if !identical {
+		t.Errorf("expected AutoOrient to undo the Exif rotation, got result %+v", result)
+	}
+}
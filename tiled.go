@@ -0,0 +1,260 @@
+/*
+Tiled Comparison
+Copyright (C) 2023 Ivan Latunov
+
+This program is free software; you can redistribute it and/or modify it under
+the terms of the GNU General Public License as published by the Free Software
+Foundation; either version 2 of the License, or (at your option) any later
+version.
+
+This program is distributed in the hope that it will be useful, but WITHOUT ANY
+WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A
+PARTICULAR PURPOSE.  See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with
+this program; if not, write to the Free Software Foundation, Inc., 59 Temple
+Place, Suite 330, Boston, MA 02111-1307 USA
+*/
+
+package perceptualdiff
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"io"
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+// tileOverlap is the number of pixels of context needed on each side of a
+// tile so its Laplacian pyramid convolutions see the same neighborhood
+// they would see in a full-image comparison. The pyramid is
+// non-decimating (every level stays full-resolution; see newPyramid),
+// and each level is one more radius-2 separable convolution of the
+// previous one, so the true spatial reach of the deepest level used,
+// MAX_PYR_LEVELS-1, is 2*(MAX_PYR_LEVELS-1) pixels — not the exponential
+// reach a halving pyramid would need. A small margin is added for
+// safety.
+const tileOverlap = 2*(MAX_PYR_LEVELS-1) + 4
+
+// tileBounds is a tile's non-overlapping region, in destination-image
+// coordinates.
+type tileBounds struct{ x0, y0, x1, y1 int }
+
+// YeeCompareTiled compares a and b the same way YeeCompare does, but
+// processes the image in tileSize x tileSize tiles concurrently instead of
+// allocating the O(width*height) buffers YeeCompare needs up front. Memory
+// use is bounded by O(tileSize^2 * numWorkers) rather than O(width*height),
+// which matters on very large (e.g. 20MP+) images.
+//
+// Tiles are expanded by tileOverlap pixels of context on each side before
+// their Laplacian pyramids are built, so boundary pixels see the same
+// neighborhood YeeCompare would give them; only the non-overlapping
+// interior of each tile contributes to the result. args.ReferenceWidth is
+// set to the full image's width for each tile's comparison, so every
+// tile's CSF model sees the same pixels-per-degree YeeCompare would use on
+// the whole image, regardless of tileSize. Tiles whose source regions are
+// byte-identical in both images are skipped without running the
+// perceptual test at all, which is the common case for UI/screenshot
+// regression diffs where most of the frame is unchanged.
+//
+// One caveat follows from tiling: ImageDifference is always rendered as
+// DiffMaskBlue regardless of args.DiffMode.
+func YeeCompareTiled(a, b image.Image, args Parameters, tileSize int, output_verbose io.Writer) (
+	perceptually_identical bool,
+	output CompareResult,
+) {
+	if output_verbose == nil {
+		output_verbose = io.Discard
+	}
+
+	a_size := a.Bounds().Size()
+	b_size := b.Bounds().Size()
+	if a_size != b_size {
+		return false, CompareResult{Reason: ReasonDimensionMismatch}
+	}
+	w, h := a_size.X, a_size.Y
+
+	tile_args := args
+	tile_args.DiffMode = DiffMaskBlue
+	tile_args.ReferenceWidth = w
+
+	diffImg := image.NewRGBA(a.Bounds())
+	var pixels_failed atomic.Uint64
+	var error_sum uint64 // will be used with the atomic* funcs as a float64
+
+	var tiles []tileBounds
+	for y := 0; y < h; y += tileSize {
+		for x := 0; x < w; x += tileSize {
+			tiles = append(tiles, tileBounds{
+				x0: x, y0: y,
+				x1: min(x+tileSize, w),
+				y1: min(y+tileSize, h),
+			})
+		}
+	}
+
+	_, _ = output_verbose.Write([]byte("Comparing tiles\n"))
+
+	sem := make(chan struct{}, runtime.NumCPU())
+	wg := sync.WaitGroup{}
+
+	for _, t := range tiles {
+		t := t
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			if tileHash(a, t) == tileHash(b, t) {
+				paintBlack(diffImg, t)
+				return
+			}
+
+			ex0 := max(t.x0-tileOverlap, 0)
+			ey0 := max(t.y0-tileOverlap, 0)
+			ex1 := min(t.x1+tileOverlap, w)
+			ey1 := min(t.y1+tileOverlap, h)
+
+			sub_a := subImage(a, ex0, ey0, ex1, ey1)
+			sub_b := subImage(b, ex0, ey0, ex1, ey1)
+
+			sub_args := offsetIgnoreParams(tile_args, ex0, ey0)
+			_, sub_result := YeeCompare(sub_a, sub_b, sub_args, nil)
+			if sub_result.ImageDifference == nil {
+				// Binary identical sub-region: nothing failed.
+				paintBlack(diffImg, t)
+				return
+			}
+
+			failed := copyInteriorAndCountFailures(diffImg, sub_result.ImageDifference, t, ex0, ey0)
+			pixels_failed.Add(failed)
+
+			atomicAddFloat64(&error_sum, interiorErrorSum(sub_result.pixelError, ex1-ex0, t, ex0, ey0))
+		}()
+	}
+	wg.Wait()
+
+	perceptually_identical = uint(pixels_failed.Load()) < args.ThresholdPixels
+	reason := ReasonVisiblyDifferent
+	if perceptually_identical {
+		reason = ReasonIndistinguishable
+	}
+
+	return perceptually_identical, CompareResult{
+		Reason:          reason,
+		NumPixelsFailed: pixels_failed.Load(),
+		ErrorSum:        atomicLoadFloat64(&error_sum),
+		ImageDifference: diffImg,
+		DiffMode:        DiffMaskBlue,
+	}
+}
+
+// tileHash hashes t's region of img, so two tiles can be compared for
+// byte-identity without holding either decoded region in memory at once.
+func tileHash(img image.Image, t tileBounds) [sha256.Size]byte {
+	hasher := sha256.New()
+	bounds := img.Bounds()
+	var buf [8]byte
+	for y := t.y0; y < t.y1; y++ {
+		for x := t.x0; x < t.x1; x++ {
+			r, g, bl, a := img.At(bounds.Min.X+x, bounds.Min.Y+y).RGBA()
+			binary.BigEndian.PutUint16(buf[0:2], uint16(r))
+			binary.BigEndian.PutUint16(buf[2:4], uint16(g))
+			binary.BigEndian.PutUint16(buf[4:6], uint16(bl))
+			binary.BigEndian.PutUint16(buf[6:8], uint16(a))
+			hasher.Write(buf[:])
+		}
+	}
+	var sum [sha256.Size]byte
+	copy(sum[:], hasher.Sum(nil))
+	return sum
+}
+
+// offsetIgnoreParams returns a copy of args with IgnoreRects and
+// IgnoreMask translated by (-dx, -dy), so they line up with a sub-image
+// whose origin sits at (dx, dy) in full-image coordinates. Without this,
+// isIgnored would test tile-local coordinates against full-image rects
+// and mask, silently masking the wrong pixels whenever a tile isn't at
+// the origin.
+func offsetIgnoreParams(args Parameters, dx, dy int) Parameters {
+	if len(args.IgnoreRects) == 0 && args.IgnoreMask == nil {
+		return args
+	}
+
+	offset := image.Pt(dx, dy)
+	if len(args.IgnoreRects) > 0 {
+		rects := make([]image.Rectangle, len(args.IgnoreRects))
+		for i, r := range args.IgnoreRects {
+			rects[i] = r.Sub(offset)
+		}
+		args.IgnoreRects = rects
+	}
+	if args.IgnoreMask != nil {
+		shifted := *args.IgnoreMask
+		shifted.Rect = shifted.Rect.Sub(offset)
+		args.IgnoreMask = &shifted
+	}
+	return args
+}
+
+// subImage copies img's [x0, x1) x [y0, y1) region into a freshly
+// allocated, zero-origin *image.RGBA.
+func subImage(img image.Image, x0, y0, x1, y1 int) *image.RGBA {
+	bounds := img.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, x1-x0, y1-y0))
+	for y := y0; y < y1; y++ {
+		for x := x0; x < x1; x++ {
+			dst.Set(x-x0, y-y0, img.At(bounds.Min.X+x, bounds.Min.Y+y))
+		}
+	}
+	return dst
+}
+
+// paintBlack fills t's region of dst with opaque black, matching the
+// "unchanged" color YeeCompare itself uses in a DiffMaskBlue mask.
+func paintBlack(dst *image.RGBA, t tileBounds) {
+	black := color.RGBA{A: 255}
+	for y := t.y0; y < t.y1; y++ {
+		for x := t.x0; x < t.x1; x++ {
+			dst.SetRGBA(x, y, black)
+		}
+	}
+}
+
+// interiorErrorSum sums sub_pixel_error, a sub-image's row-major per-pixel
+// error values (sub_width wide), over t's interior region only. ex0, ey0
+// are the sub-image's origin in the same coordinate space as t.
+func interiorErrorSum(sub_pixel_error []float64, sub_width int, t tileBounds, ex0, ey0 int) float64 {
+	var sum float64
+	for y := t.y0; y < t.y1; y++ {
+		row := (y - ey0) * sub_width
+		for x := t.x0; x < t.x1; x++ {
+			sum += sub_pixel_error[row+(x-ex0)]
+		}
+	}
+	return sum
+}
+
+// copyInteriorAndCountFailures copies t's interior (non-overlap) region of
+// tileMask, a DiffMaskBlue-rendered tile mask whose origin (ex0, ey0) sits
+// at (0, 0), into dst and returns how many of those pixels are blue, i.e.
+// failed the perceptual test.
+func copyInteriorAndCountFailures(dst, tileMask *image.RGBA, t tileBounds, ex0, ey0 int) uint64 {
+	var failed uint64
+	blue := color.RGBA{B: 255, A: 255}
+	for y := t.y0; y < t.y1; y++ {
+		for x := t.x0; x < t.x1; x++ {
+			c := tileMask.RGBAAt(x-ex0, y-ey0)
+			dst.SetRGBA(x, y, c)
+			if c == blue {
+				failed++
+			}
+		}
+	}
+	return failed
+}
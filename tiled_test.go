@@ -0,0 +1,117 @@
+package perceptualdiff_test
+
+import (
+	"image"
+	"image/color"
+	"testing"
+
+	"github.com/xswordsx/perceptualdiff"
+)
+
+func checkerboardImage(w, h int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			c := color.RGBA{uint8(x * 3 % 256), uint8(y * 5 % 256), uint8((x + y) * 2 % 256), 255}
+			img.Set(x, y, c)
+		}
+	}
+	return img
+}
+
+func TestYeeCompareTiledMatchesFullImage(t *testing.T) {
+	const w, h = 48, 48
+	a := checkerboardImage(w, h)
+	b := checkerboardImage(w, h)
+
+	// Localize a visible change in one corner, leaving most tiles
+	// byte-identical between a and b.
+	for y := 0; y < 10; y++ {
+		for x := 0; x < 10; x++ {
+			b.Set(x, y, color.RGBA{255, 255, 255, 255})
+		}
+	}
+
+	fullIdentical, full := perceptualdiff.YeeCompare(a, b, perceptualdiff.DefaultParameters, nil)
+	tiledIdentical, tiled := perceptualdiff.YeeCompareTiled(a, b, perceptualdiff.DefaultParameters, 16, nil)
+
+	if fullIdentical != tiledIdentical {
+		t.Errorf("YeeCompare identical=%v but YeeCompareTiled identical=%v", fullIdentical, tiledIdentical)
+	}
+	if full.NumPixelsFailed != tiled.NumPixelsFailed {
+		t.Errorf("NumPixelsFailed: full=%d tiled=%d", full.NumPixelsFailed, tiled.NumPixelsFailed)
+	}
+
+	if full.ErrorSum == 0 {
+		t.Fatalf("expected nonzero ErrorSum for a changed image")
+	}
+	if ratio := tiled.ErrorSum / full.ErrorSum; ratio < 0.99 || ratio > 1.01 {
+		t.Errorf("tiled ErrorSum %v diverges from full ErrorSum %v (ratio %v)", tiled.ErrorSum, full.ErrorSum, ratio)
+	}
+
+	// An unchanged pixel, far from the localized diff, must render black in
+	// both the full and tiled masks.
+	unchanged := color.RGBA{0, 0, 0, 255}
+	if c := full.ImageDifference.At(w-1, h-1); c != unchanged {
+		t.Errorf("full.ImageDifference unchanged pixel = %v, want %v", c, unchanged)
+	}
+	if c := tiled.ImageDifference.At(w-1, h-1); c != unchanged {
+		t.Errorf("tiled.ImageDifference unchanged pixel = %v, want %v", c, unchanged)
+	}
+}
+
+func TestYeeCompareTiledIgnoreRectsAreTileLocal(t *testing.T) {
+	const w, h = 128, 128
+	a := checkerboardImage(w, h)
+	b := checkerboardImage(w, h)
+
+	// A visible change inside a tile that is nowhere near the origin, so
+	// ex0/ey0 for that tile are > 0 and IgnoreRects must be translated
+	// into sub-image-local coordinates to mask the right pixels.
+	changed := image.Rect(96, 96, 112, 112)
+	for y := changed.Min.Y; y < changed.Max.Y; y++ {
+		for x := changed.Min.X; x < changed.Max.X; x++ {
+			b.Set(x, y, color.RGBA{255, 255, 255, 255})
+		}
+	}
+
+	params := perceptualdiff.DefaultParameters
+	params.IgnoreRects = []image.Rectangle{changed}
+
+	fullIdentical, full := perceptualdiff.YeeCompare(a, b, params, nil)
+	tiledIdentical, tiled := perceptualdiff.YeeCompareTiled(a, b, params, 32, nil)
+
+	if !fullIdentical || !tiledIdentical {
+		t.Fatalf("expected the masked-out change to be ignored: full=%v tiled=%v", fullIdentical, tiledIdentical)
+	}
+	if full.NumPixelsFailed != tiled.NumPixelsFailed {
+		t.Errorf("NumPixelsFailed: full=%d tiled=%d", full.NumPixelsFailed, tiled.NumPixelsFailed)
+	}
+	if full.ErrorSum != 0 || tiled.ErrorSum != 0 {
+		t.Errorf("expected zero ErrorSum with the whole diff masked out, got full=%v tiled=%v", full.ErrorSum, tiled.ErrorSum)
+	}
+
+	gray := color.RGBA{128, 128, 128, 255}
+	if c := tiled.ImageDifference.At(100, 100); c != gray {
+		t.Errorf("tiled.ImageDifference inside IgnoreRects = %v, want %v (gray)", c, gray)
+	}
+}
+
+func TestYeeCompareTiledByteIdenticalImages(t *testing.T) {
+	a := checkerboardImage(32, 32)
+
+	identical, result := perceptualdiff.YeeCompareTiled(a, a, perceptualdiff.DefaultParameters, 16, nil)
+	if !identical {
+		t.Errorf("expected a byte-identical image to compare identical, got result %+v", result)
+	}
+	if result.NumPixelsFailed != 0 {
+		t.Errorf("expected 0 failed pixels, got %d", result.NumPixelsFailed)
+	}
+
+	black := color.RGBA{0, 0, 0, 255}
+	for _, p := range []image.Point{{0, 0}, {31, 31}, {15, 15}} {
+		if c := result.ImageDifference.At(p.X, p.Y); c != black {
+			t.Errorf("ImageDifference at %v = %v, want %v", p, c, black)
+		}
+	}
+}